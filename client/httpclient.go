@@ -8,10 +8,13 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"gitlab.com/gitlab-org/labkit/correlation"
 	"gitlab.com/gitlab-org/labkit/tracing"
 	"gitlab.com/gitlab-org/labkit/log"
@@ -23,6 +26,11 @@ const (
 	httpProtocol              = "http://"
 	httpsProtocol             = "https://"
 	defaultReadTimeoutSeconds = 300
+
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 100
+	defaultMaxConnsPerHost     = 0
+	defaultIdleConnTimeout     = 90 * time.Second
 )
 
 type HttpClient struct {
@@ -33,6 +41,36 @@ type HttpClient struct {
 type httpClientCfg struct {
 	keyPath, certPath string
 	caFile, caPath    string
+
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	expectContinueTimeout time.Duration
+	responseHeaderTimeout time.Duration
+
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	maxConnsPerHost     int
+	idleConnTimeout     time.Duration
+
+	proxyFromEnvironment bool
+
+	getClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+	certPool *x509.CertPool
+
+	retryPolicy    *retryPolicy
+	circuitBreaker *circuitBreakerPolicy
+
+	metrics *httpMetrics
+}
+
+func defaultHttpClientCfg() httpClientCfg {
+	return httpClientCfg{
+		maxIdleConns:        defaultMaxIdleConns,
+		maxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		maxConnsPerHost:     defaultMaxConnsPerHost,
+		idleConnTimeout:     defaultIdleConnTimeout,
+	}
 }
 
 func (hcc httpClientCfg) HaveCertAndKey() bool { return hcc.keyPath != "" && hcc.certPath != "" }
@@ -49,6 +87,133 @@ func WithClientCert(certPath, keyPath string) HTTPClientOpt {
 	}
 }
 
+// WithClientCertificateFunc configures the HttpClient to fetch its client
+// certificate lazily through getClientCertificate whenever the server
+// requests one, instead of loading it once at construction time. This is
+// wired into tls.Config.GetClientCertificate, so it takes precedence over
+// WithClientCert.
+func WithClientCertificateFunc(getClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.getClientCertificate = getClientCertificate
+	}
+}
+
+// WithReloadableClientCert configures the HttpClient to serve its client
+// certificate from certPath/keyPath, re-reading the pair from disk whenever
+// it is older than reloadInterval. This lets long-running processes pick up
+// rotated mTLS certificates without a restart.
+func WithReloadableClientCert(certPath, keyPath string, reloadInterval time.Duration) HTTPClientOpt {
+	reloader := &reloadableClientCert{certPath: certPath, keyPath: keyPath, reloadInterval: reloadInterval}
+
+	return WithClientCertificateFunc(reloader.GetClientCertificate)
+}
+
+// reloadableClientCert caches a parsed client certificate and transparently
+// re-reads it from disk once reloadInterval has elapsed, so certificate
+// rotation doesn't require restarting the process.
+type reloadableClientCert struct {
+	certPath, keyPath string
+	reloadInterval    time.Duration
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	loadedAt time.Time
+}
+
+func (r *reloadableClientCert) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cert == nil || time.Since(r.loadedAt) >= r.reloadInterval {
+		cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+		if err != nil {
+			if r.cert != nil {
+				return r.cert, nil
+			}
+			return nil, err
+		}
+
+		r.cert = &cert
+		r.loadedAt = time.Now()
+	}
+
+	return r.cert, nil
+}
+
+// WithCertPool overrides the shared, lazily-loaded certificate pool that
+// buildHttpsTransport would otherwise build from the system pool and the
+// SSL_CERT_FILE/SSL_CERT_DIR environment variables. Intended for tests.
+func WithCertPool(pool *x509.CertPool) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.certPool = pool
+	}
+}
+
+// WithRetryPolicy configures bounded retries with exponential backoff and
+// jitter for idempotent requests that fail with a retryable network error or
+// a 429/502/503/504 response. maxAttempts includes the initial attempt. The
+// backoff starts at base and doubles on each further attempt up to max, and
+// each delay is jittered to between half and all of that value. A Retry-After
+// header on the failed response replaces the backoff delay and is not capped
+// by max.
+func WithRetryPolicy(maxAttempts int, base, max time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.retryPolicy = &retryPolicy{maxAttempts: maxAttempts, base: base, max: max}
+	}
+}
+
+// WithCircuitBreaker trips a per-host circuit breaker after threshold
+// consecutive request failures, rejecting further requests to that host with
+// ErrCircuitOpen until cooldown has elapsed.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.circuitBreaker = &circuitBreakerPolicy{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// WithMetrics instruments every request made by the HttpClient with
+// Prometheus collectors registered against registerer, using namespace as
+// their metric namespace: request duration, in-flight requests, response
+// size, DNS/TLS handshake timings, and (alongside WithRetryPolicy /
+// WithCircuitBreaker) retry and circuit-breaker-open counts.
+func WithMetrics(registerer prometheus.Registerer, namespace string) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.metrics = newHTTPMetrics(registerer, namespace)
+	}
+}
+
+// WithConnectionPool configures the idle connection pool limits used by every
+// transport variant. A maxPerHost of 0 means no limit, matching
+// net/http.Transport's own default.
+func WithConnectionPool(maxIdle, maxIdlePerHost, maxPerHost int, idleTimeout time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.maxIdleConns = maxIdle
+		hcc.maxIdleConnsPerHost = maxIdlePerHost
+		hcc.maxConnsPerHost = maxPerHost
+		hcc.idleConnTimeout = idleTimeout
+	}
+}
+
+// WithProxyFromEnvironment configures the transport to honor the
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables, as described by
+// net/http.ProxyFromEnvironment.
+func WithProxyFromEnvironment() HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.proxyFromEnvironment = true
+	}
+}
+
+// WithDialTimeouts configures the dial, TLS handshake, expect-continue and
+// response header timeouts applied to every transport variant.
+func WithDialTimeouts(dialTimeout, tlsHandshakeTimeout, expectContinueTimeout, responseHeaderTimeout time.Duration) HTTPClientOpt {
+	return func(hcc *httpClientCfg) {
+		hcc.dialTimeout = dialTimeout
+		hcc.tlsHandshakeTimeout = tlsHandshakeTimeout
+		hcc.expectContinueTimeout = expectContinueTimeout
+		hcc.responseHeaderTimeout = responseHeaderTimeout
+	}
+}
+
 // Deprecated: use NewHTTPClientWithOpts - https://gitlab.com/gitlab-org/gitlab-shell/-/issues/484
 func NewHTTPClient(gitlabURL, gitlabRelativeURLRoot, caFile, caPath string, selfSignedCert bool, readTimeoutSeconds uint64) *HttpClient {
 	c, err := NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath, selfSignedCert, readTimeoutSeconds, nil)
@@ -60,24 +225,23 @@ func NewHTTPClient(gitlabURL, gitlabRelativeURLRoot, caFile, caPath string, self
 
 // NewHTTPClientWithOpts builds an HTTP client using the provided options
 func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath string, selfSignedCert bool, readTimeoutSeconds uint64, opts []HTTPClientOpt) (*HttpClient, error) {
-	hcc := &httpClientCfg{
-		caFile: caFile,
-		caPath: caPath,
-	}
+	hcc := defaultHttpClientCfg()
+	hcc.caFile = caFile
+	hcc.caPath = caPath
 
 	for _, opt := range opts {
-		opt(hcc)
+		opt(&hcc)
 	}
 
 	var transport *http.Transport
 	var host string
 	var err error
 	if strings.HasPrefix(gitlabURL, unixSocketProtocol) {
-		transport, host = buildSocketTransport(gitlabURL, gitlabRelativeURLRoot)
+		transport, host = buildSocketTransport(hcc, gitlabURL, gitlabRelativeURLRoot)
 	} else if strings.HasPrefix(gitlabURL, httpProtocol) {
-		transport, host = buildHttpTransport(gitlabURL)
+		transport, host = buildHttpTransport(hcc, gitlabURL)
 	} else if strings.HasPrefix(gitlabURL, httpsProtocol) {
-		transport, host, err = buildHttpsTransport(*hcc, selfSignedCert, gitlabURL)
+		transport, host, err = buildHttpsTransport(hcc, selfSignedCert, gitlabURL)
 		if err != nil {
 			return nil, err
 		}
@@ -85,8 +249,18 @@ func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath stri
 		return nil, errors.New("unknown GitLab URL prefix")
 	}
 
+	var roundTripper http.RoundTripper = transport
+	if hcc.metrics != nil {
+		roundTripper = newMetricsRoundTripper(roundTripper, hcc.metrics)
+	}
+	if hcc.retryPolicy != nil || hcc.circuitBreaker != nil {
+		retrying := newRetryingRoundTripper(roundTripper, hcc.retryPolicy, hcc.circuitBreaker)
+		retrying.metrics = hcc.metrics
+		roundTripper = retrying
+	}
+
 	c := &http.Client{
-		Transport: correlation.NewInstrumentedRoundTripper(tracing.NewRoundTripper(transport)),
+		Transport: correlation.NewInstrumentedRoundTripper(tracing.NewRoundTripper(roundTripper)),
 		Timeout:   readTimeout(readTimeoutSeconds),
 	}
 
@@ -95,15 +269,16 @@ func NewHTTPClientWithOpts(gitlabURL, gitlabRelativeURLRoot, caFile, caPath stri
 	return client, nil
 }
 
-func buildSocketTransport(gitlabURL, gitlabRelativeURLRoot string) (*http.Transport, string) {
+func buildSocketTransport(hcc httpClientCfg, gitlabURL, gitlabRelativeURLRoot string) (*http.Transport, string) {
 	socketPath := strings.TrimPrefix(gitlabURL, unixSocketProtocol)
 
 	transport := &http.Transport{
 		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
-			dialer := net.Dialer{}
+			dialer := net.Dialer{Timeout: hcc.dialTimeout}
 			return dialer.DialContext(ctx, "unix", socketPath)
 		},
 	}
+	applyTransportDefaults(transport, hcc)
 
 	host := socketBaseUrl
 	gitlabRelativeURLRoot = strings.Trim(gitlabRelativeURLRoot, "/")
@@ -114,11 +289,58 @@ func buildSocketTransport(gitlabURL, gitlabRelativeURLRoot string) (*http.Transp
 	return transport, host
 }
 
-func buildHttpsTransport(hcc httpClientCfg, selfSignedCert bool, gitlabURL string) (*http.Transport, string, error) {
-	certPool, err := x509.SystemCertPool()
+var (
+	sharedCertPoolOnce sync.Once
+	sharedCertPool     *x509.CertPool
+)
 
-	if err != nil {
-		certPool = x509.NewCertPool()
+// loadSharedCertPool lazily builds, and caches for the lifetime of the
+// process, a certificate pool seeded from the system pool plus any
+// certificates named by the SSL_CERT_FILE/SSL_CERT_DIR environment
+// variables. This avoids re-reading the system pool (and those files) on
+// every NewHTTPClientWithOpts call.
+func loadSharedCertPool() *x509.CertPool {
+	sharedCertPoolOnce.Do(func() {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		addCertsFromEnv(pool)
+
+		sharedCertPool = pool
+	})
+
+	return sharedCertPool
+}
+
+// addCertsFromEnv merges certificates named by the SSL_CERT_FILE/SSL_CERT_DIR
+// environment variables into pool. Split out of loadSharedCertPool so the
+// merge logic can be tested without going through the process-lifetime
+// sync.Once cache.
+func addCertsFromEnv(pool *x509.CertPool) {
+	if certFile := os.Getenv("SSL_CERT_FILE"); certFile != "" {
+		addCertToPool(pool, certFile)
+	}
+
+	if certDir := os.Getenv("SSL_CERT_DIR"); certDir != "" {
+		fis, _ := ioutil.ReadDir(certDir)
+		for _, fi := range fis {
+			if fi.IsDir() {
+				continue
+			}
+
+			addCertToPool(pool, filepath.Join(certDir, fi.Name()))
+		}
+	}
+}
+
+func buildHttpsTransport(hcc httpClientCfg, selfSignedCert bool, gitlabURL string) (*http.Transport, string, error) {
+	certPool := hcc.certPool
+	if certPool != nil {
+		certPool = certPool.Clone()
+	} else {
+		certPool = loadSharedCertPool().Clone()
 	}
 
 	if hcc.caFile != "" {
@@ -141,7 +363,9 @@ func buildHttpsTransport(hcc httpClientCfg, selfSignedCert bool, gitlabURL strin
 		MinVersion:         tls.VersionTLS12,
 	}
 
-	if hcc.HaveCertAndKey() {
+	if hcc.getClientCertificate != nil {
+		tlsConfig.GetClientCertificate = hcc.getClientCertificate
+	} else if hcc.HaveCertAndKey() {
 		cert, err := tls.LoadX509KeyPair(hcc.certPath, hcc.keyPath)
 		if err != nil {
 			return nil, "", err
@@ -153,8 +377,9 @@ func buildHttpsTransport(hcc httpClientCfg, selfSignedCert bool, gitlabURL strin
 	transport := &http.Transport{
 		TLSClientConfig: tlsConfig,
 	}
+	applyTransportDefaults(transport, hcc)
 
-	return transport, gitlabURL, err
+	return transport, gitlabURL, nil
 }
 
 func addCertToPool(certPool *x509.CertPool, fileName string) {
@@ -164,8 +389,32 @@ func addCertToPool(certPool *x509.CertPool, fileName string) {
 	}
 }
 
-func buildHttpTransport(gitlabURL string) (*http.Transport, string) {
-	return &http.Transport{}, gitlabURL
+func buildHttpTransport(hcc httpClientCfg, gitlabURL string) (*http.Transport, string) {
+	transport := &http.Transport{}
+	applyTransportDefaults(transport, hcc)
+
+	return transport, gitlabURL
+}
+
+// applyTransportDefaults wires the pooling, proxy and timeout options shared by
+// every transport variant onto the given http.Transport.
+func applyTransportDefaults(transport *http.Transport, hcc httpClientCfg) {
+	transport.MaxIdleConns = hcc.maxIdleConns
+	transport.MaxIdleConnsPerHost = hcc.maxIdleConnsPerHost
+	transport.MaxConnsPerHost = hcc.maxConnsPerHost
+	transport.IdleConnTimeout = hcc.idleConnTimeout
+	transport.TLSHandshakeTimeout = hcc.tlsHandshakeTimeout
+	transport.ExpectContinueTimeout = hcc.expectContinueTimeout
+	transport.ResponseHeaderTimeout = hcc.responseHeaderTimeout
+
+	if hcc.proxyFromEnvironment {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	if hcc.dialTimeout != 0 && transport.DialContext == nil {
+		dialer := net.Dialer{Timeout: hcc.dialTimeout}
+		transport.DialContext = dialer.DialContext
+	}
 }
 
 func readTimeout(timeoutSeconds uint64) time.Duration {
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testCACertPEM = `-----BEGIN CERTIFICATE-----
+MIIC4TCCAcmgAwIBAgIBATANBgkqhkiG9w0BAQsFADASMRAwDgYDVQQDEwd0ZXN0
+LWNhMB4XDTI2MDcyNzIyNTAxN1oXDTM2MDcyNDIyNTAxN1owEjEQMA4GA1UEAxMH
+dGVzdC1jYTCCASIwDQYJKoZIhvcNAQEBBQADggEPADCCAQoCggEBALXRldPkvUiS
+VF1oYxlyAlramPezoZcnjOFYTJ+4+zfGPpXZ6NPTt9+keEnvn8LufpXJVJ2SBNuZ
+EUQDInCW1pc2ztlj9EXpvVkc97VBpIA1oFlgmeirixFjDgqZaoxtSowDZm2LMZ8L
++GBmVG0GTtrSou8GkwXjv4sQ8kDE2vY9mU5C7wUg+DZLgShgvDtl3QATBDSJnUQ3
+EleLLlZzr628TsbNz9AOYVlR4Mi3av859Ta/PUpfUtCeMKOkVChq5bZwPXI7KpdH
+nlqjeZmUYLHaRzU4p7ldBInyvXyWX3VUnKpAujZxaPuM37SmTzuTklhvhY7fki1h
+urWZyvMODkECAwEAAaNCMEAwDgYDVR0PAQH/BAQDAgKEMA8GA1UdEwEB/wQFMAMB
+Af8wHQYDVR0OBBYEFADGF09D6pCCOu8WRdOaFDpgkkJaMA0GCSqGSIb3DQEBCwUA
+A4IBAQAhQW05F/FV3OB0ffcZgCi0YOUYC/Rzm/eU37PixsF0fHFculuEMVqYZeJJ
+Il1k0Cm1CZ73ZJXGd8KjxZhlVRqOwjhBVZeVFrSX2auVjocgOfl0PfKezRM7jpzx
+PlMZfGCPqLa7kbomtwvqYsR8DGZa742zgq3wHz9LltDYCdh6oPKMtFEyqg3d0SF7
+jnUIX+tAAHwsY3NCJm8MF35TTx0iJQUcJOkFLVvn/N1cMphYayQWFZMyVaKcukgb
+d3dN67q1cZU8ONoyv8t+qWfVGpmEOrRFJB9I/0NYNTajP2/Em3nl//yjcqyDZY4i
+3/JGdX0OL/9oWYBV19ZjxDu3VL0E
+-----END CERTIFICATE-----
+`
+
+const testCACert2PEM = `-----BEGIN CERTIFICATE-----
+MIIC5TCCAc2gAwIBAgIBAjANBgkqhkiG9w0BAQsFADAUMRIwEAYDVQQDEwl0ZXN0
+LWNhLTIwHhcNMjYwNzI3MjI1MzI4WhcNMzYwNzI0MjI1MzI4WjAUMRIwEAYDVQQD
+Ewl0ZXN0LWNhLTIwggEiMA0GCSqGSIb3DQEBAQUAA4IBDwAwggEKAoIBAQDGvyTV
+7YwDd5DlMwY/vvXr3slqGKx7YNtBVL7okfdv+bEvs63n7DNGVbnsc/Oaf1naLYCr
+MpszB3cDeksdTfUKlnb25bNyuPF1yik8DUVR4su61OV6cNYF/1lCp5ePzt52ZmyA
+5n1vQRfg+6yDbeUYUxmSy2oNbDNV3aRegdKLEuxJIfvFmF78hA3rlc3KopM1bSHT
+BCJrN+2YwHtGj4nWsSXvUGh2Wb8tC3pczxOxRGv67baL1H7ANkCXZf7pXDTOm94q
+a6lyRhODjNhs8BnppbKvX//jgzZRuWs2nKl/3TquMNlzvoGrQJor2JQOcbrQ6A4b
+baNJQ/XV/0PyCqepAgMBAAGjQjBAMA4GA1UdDwEB/wQEAwIChDAPBgNVHRMBAf8E
+BTADAQH/MB0GA1UdDgQWBBR/ro4Yx8WXJ/O7AgBV1WSHspgR+TANBgkqhkiG9w0B
+AQsFAAOCAQEApPh1+akz7AIUrbvuJxNpD4DlTmjU0L911IQd56CQS4qFRuDjGbgf
+jDtnLJa6aesTpHeol+fiNpnyo1ZvXTRt4x4Ku0g5P9Vb281CGJA5QxKzMz1vKEo6
+kOZRgqVtoKXanDQ+gIMt67pmjRsGeJ4MfpYtb1yz6nqjqr1ci+5p7uVLOALjwAs+
+M5A15OmV8GP7hXILfHePBy/D/xjpDd29QHUX0G7tYTTEoGArkYJ36q1JGUPme9xV
+8qrm8pWsQwOGTe09asWWvBmC5b9tYx04n23wndafiWD/qorgxMrEEcDGWdH/76Pq
+Y5EU3zZjP0XXKJIQrFMCh/Twn1mPinfx5Q==
+-----END CERTIFICATE-----
+`
+
+func TestAddCertsFromEnv_MergesSSLCertFileAndSSLCertDir(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile := filepath.Join(dir, "file-ca.pem")
+	if err := ioutil.WriteFile(certFile, []byte(testCACertPEM), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	certDir := filepath.Join(dir, "certs.d")
+	if err := os.Mkdir(certDir, 0o700); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(certDir, "dir-ca.pem"), []byte(testCACert2PEM), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	t.Setenv("SSL_CERT_FILE", certFile)
+	t.Setenv("SSL_CERT_DIR", certDir)
+
+	pool := x509.NewCertPool()
+	addCertsFromEnv(pool)
+
+	subjects := pool.Subjects() //nolint:staticcheck // test-only use of the deprecated accessor
+	if len(subjects) != 2 {
+		t.Fatalf("expected 2 certs merged in from SSL_CERT_FILE + SSL_CERT_DIR, got %d", len(subjects))
+	}
+}
+
+func TestAddCertsFromEnv_IgnoresUnsetVars(t *testing.T) {
+	t.Setenv("SSL_CERT_FILE", "")
+	t.Setenv("SSL_CERT_DIR", "")
+
+	pool := x509.NewCertPool()
+	addCertsFromEnv(pool)
+
+	if len(pool.Subjects()) != 0 { //nolint:staticcheck // test-only use of the deprecated accessor
+		t.Fatalf("expected no certs added when SSL_CERT_FILE/SSL_CERT_DIR are unset")
+	}
+}
+
+func TestWithConnectionPool_OverridesDefaults(t *testing.T) {
+	hcc := defaultHttpClientCfg()
+	WithConnectionPool(10, 20, 30, 0)(&hcc)
+
+	if hcc.maxIdleConns != 10 || hcc.maxIdleConnsPerHost != 20 || hcc.maxConnsPerHost != 30 {
+		t.Fatalf("WithConnectionPool did not apply the given limits: %+v", hcc)
+	}
+}
+
+func TestNewHTTPClientWithOpts_UnknownURLPrefix(t *testing.T) {
+	if _, err := NewHTTPClientWithOpts("ftp://example.com", "", "", "", false, 0, nil); err == nil {
+		t.Fatalf("expected an error for an unrecognized GitLab URL prefix")
+	}
+}
+
+// WithCertPool is documented as being intended for tests, where the same
+// pool is commonly reused across cases or clients; buildHttpsTransport must
+// not mutate it in place.
+func TestWithCertPool_DoesNotMutateCallersPool(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "extra-ca.pem")
+	if err := ioutil.WriteFile(caFile, []byte(testCACertPEM), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	before := len(pool.Subjects()) //nolint:staticcheck // test-only use of the deprecated accessor
+
+	hcc := defaultHttpClientCfg()
+	hcc.caFile = caFile
+	WithCertPool(pool)(&hcc)
+
+	if _, _, err := buildHttpsTransport(hcc, false, "https://example.com"); err != nil {
+		t.Fatalf("buildHttpsTransport: %v", err)
+	}
+
+	after := len(pool.Subjects()) //nolint:staticcheck // test-only use of the deprecated accessor
+	if after != before {
+		t.Fatalf("expected the caller's cert pool to be untouched (had %d subjects, now has %d)", before, after)
+	}
+}
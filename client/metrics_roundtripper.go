@@ -0,0 +1,149 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// httpMetrics holds the Prometheus collectors shared by every instrumented
+// request made through an HttpClient.
+type httpMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	responseSize    *prometheus.HistogramVec
+	dnsDuration     *prometheus.HistogramVec
+	tlsDuration     *prometheus.HistogramVec
+	retries         *prometheus.CounterVec
+	circuitOpen     *prometheus.CounterVec
+}
+
+type httpMetricsKey struct {
+	registerer prometheus.Registerer
+	namespace  string
+}
+
+var (
+	httpMetricsMu    sync.Mutex
+	httpMetricsCache = make(map[httpMetricsKey]*httpMetrics)
+)
+
+// newHTTPMetrics returns the httpMetrics for the given (registerer,
+// namespace) pair, registering its collectors the first time that pair is
+// seen and reusing them on every subsequent call. Without this, building a
+// second HttpClient with WithMetrics against the same registerer/namespace
+// (e.g. on config reload) would panic on duplicate collector registration.
+func newHTTPMetrics(registerer prometheus.Registerer, namespace string) *httpMetrics {
+	key := httpMetricsKey{registerer: registerer, namespace: namespace}
+
+	httpMetricsMu.Lock()
+	defer httpMetricsMu.Unlock()
+
+	if metrics, ok := httpMetricsCache[key]; ok {
+		return metrics
+	}
+
+	metrics := buildHTTPMetrics(registerer, namespace)
+	httpMetricsCache[key] = metrics
+	return metrics
+}
+
+func buildHTTPMetrics(registerer prometheus.Registerer, namespace string) *httpMetrics {
+	factory := promauto.With(registerer)
+
+	return &httpMetrics{
+		requestDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_client_request_duration_seconds",
+			Help:      "Duration in seconds of requests made by the internal API HTTP client",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		inFlight: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_client_in_flight_requests",
+			Help:      "Number of in-flight requests currently being made by the internal API HTTP client",
+		}),
+		responseSize: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_client_response_size_bytes",
+			Help:      "Size in bytes of responses received by the internal API HTTP client",
+			Buckets:   prometheus.ExponentialBuckets(128, 8, 6),
+		}, []string{"method", "code"}),
+		dnsDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_client_dns_lookup_duration_seconds",
+			Help:      "Duration in seconds of DNS lookups made by the internal API HTTP client",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		tlsDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_client_tls_handshake_duration_seconds",
+			Help:      "Duration in seconds of TLS handshakes made by the internal API HTTP client",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		retries: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_client_retries_total",
+			Help:      "Total number of retried requests made by the internal API HTTP client",
+		}, []string{"host"}),
+		circuitOpen: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_client_circuit_breaker_open_total",
+			Help:      "Total number of times the internal API HTTP client circuit breaker has opened for a host",
+		}, []string{"host"}),
+	}
+}
+
+// metricsRoundTripper instruments requests with Prometheus collectors and
+// httptrace-derived DNS/TLS handshake timings.
+type metricsRoundTripper struct {
+	next    http.RoundTripper
+	metrics *httpMetrics
+}
+
+func newMetricsRoundTripper(next http.RoundTripper, metrics *httpMetrics) http.RoundTripper {
+	return &metricsRoundTripper{next: next, metrics: metrics}
+}
+
+func (rt *metricsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.metrics.inFlight.Inc()
+	defer rt.metrics.inFlight.Dec()
+
+	var dnsStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			if !dnsStart.IsZero() {
+				rt.metrics.dnsDuration.WithLabelValues(req.Method).Observe(time.Since(dnsStart).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			if !tlsStart.IsZero() {
+				rt.metrics.tlsDuration.WithLabelValues(req.Method).Observe(time.Since(tlsStart).Seconds())
+			}
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := rt.next.RoundTrip(req)
+	duration := time.Since(start).Seconds()
+
+	code := "error"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+		if resp.ContentLength >= 0 {
+			rt.metrics.responseSize.WithLabelValues(req.Method, code).Observe(float64(resp.ContentLength))
+		}
+	}
+	rt.metrics.requestDuration.WithLabelValues(req.Method, code).Observe(duration)
+
+	return resp, err
+}
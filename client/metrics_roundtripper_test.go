@@ -0,0 +1,100 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestNewHTTPMetrics_ReusesCollectorsForSameRegistererAndNamespace(t *testing.T) {
+	// Reset the package-level cache so this test is independent of whatever
+	// other tests in this package have already registered.
+	httpMetricsMu.Lock()
+	httpMetricsCache = make(map[httpMetricsKey]*httpMetrics)
+	httpMetricsMu.Unlock()
+
+	reg := prometheus.NewRegistry()
+
+	first := newHTTPMetrics(reg, "gitlab_shell")
+	second := newHTTPMetrics(reg, "gitlab_shell")
+
+	if first != second {
+		t.Fatalf("expected the same *httpMetrics instance to be reused for an identical (registerer, namespace) pair")
+	}
+}
+
+func TestWithMetrics_DoesNotPanicOnRepeatedCalls(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	// Building two HttpClients with WithMetrics against the same registerer
+	// (e.g. a client rebuilt on config reload) must not panic with a
+	// duplicate collector registration error.
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("WithMetrics panicked on repeated registration: %v", r)
+		}
+	}()
+
+	opt := WithMetrics(reg, "gitlab_shell_repeat")
+
+	var hcc1, hcc2 httpClientCfg
+	opt(&hcc1)
+	opt(&hcc2)
+
+	if hcc1.metrics == nil || hcc2.metrics == nil {
+		t.Fatalf("expected WithMetrics to set metrics on both configs")
+	}
+}
+
+func TestMetricsRoundTripper_RecordsRequestDuration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics := newHTTPMetrics(reg, "gitlab_shell_duration")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	rt := newMetricsRoundTripper(http.DefaultTransport, metrics)
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+
+	count := testutilCollect(t, reg, "gitlab_shell_duration_http_client_request_duration_seconds")
+	if count == 0 {
+		t.Fatalf("expected the request duration histogram to have observations")
+	}
+}
+
+// testutilCollect gathers the named metric family from reg and returns its
+// total sample count, without pulling in the prometheus/testutil dependency.
+func testutilCollect(t *testing.T, reg *prometheus.Registry, name string) uint64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		var total uint64
+		for _, metric := range family.GetMetric() {
+			if h := metric.GetHistogram(); h != nil {
+				total += h.GetSampleCount()
+			}
+		}
+		return total
+	}
+
+	return 0
+}
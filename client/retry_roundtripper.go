@@ -0,0 +1,230 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.com/gitlab-org/labkit/log"
+)
+
+// ErrCircuitOpen is returned when a request is rejected because the circuit
+// breaker for its host is currently open.
+var ErrCircuitOpen = errors.New("circuit breaker is open for this host")
+
+var retryableMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+type retryPolicy struct {
+	maxAttempts int
+	base, max   time.Duration
+}
+
+type circuitBreakerPolicy struct {
+	threshold int
+	cooldown  time.Duration
+}
+
+// RetryingRoundTripper wraps an http.RoundTripper with bounded retries and an
+// optional per-host circuit breaker.
+type RetryingRoundTripper struct {
+	next    http.RoundTripper
+	retry   *retryPolicy
+	breaker *circuitBreakerPolicy
+	metrics *httpMetrics
+
+	mu    sync.Mutex
+	hosts map[string]*hostBreakerState
+}
+
+type hostBreakerState struct {
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+// newRetryingRoundTripper wraps next with retry and/or circuit-breaker
+// behavior. Either policy may be nil to disable that behavior.
+func newRetryingRoundTripper(next http.RoundTripper, retry *retryPolicy, breaker *circuitBreakerPolicy) *RetryingRoundTripper {
+	return &RetryingRoundTripper{
+		next:    next,
+		retry:   retry,
+		breaker: breaker,
+		hosts:   make(map[string]*hostBreakerState),
+	}
+}
+
+func (rt *RetryingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.breakerOpen(req.Host) {
+		return nil, ErrCircuitOpen
+	}
+
+	maxAttempts := 1
+	if rt.retry != nil && rt.retry.maxAttempts > 1 {
+		maxAttempts = rt.retry.maxAttempts
+	}
+
+	var resp *http.Response
+	var err error
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			if waitErr := rt.wait(req.Context(), attempt, resp); waitErr != nil {
+				return nil, waitErr
+			}
+
+			if req.Body != nil && req.Body != http.NoBody {
+				body, bodyErr := req.GetBody()
+				if bodyErr != nil {
+					return nil, bodyErr
+				}
+				req.Body = body
+			}
+		}
+
+		resp, err = rt.next.RoundTrip(req)
+
+		if !rt.shouldRetry(req, resp, err, attempt, maxAttempts) {
+			break
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if rt.metrics != nil {
+			rt.metrics.retries.WithLabelValues(req.Host).Inc()
+		}
+
+		log.WithFields(log.Fields{"host": req.Host, "attempt": attempt + 1}).
+			Info("retrying internal API request")
+	}
+
+	rt.recordResult(req.Host, err == nil && resp != nil && resp.StatusCode < 500)
+
+	return resp, err
+}
+
+func (rt *RetryingRoundTripper) shouldRetry(req *http.Request, resp *http.Response, err error, attempt, maxAttempts int) bool {
+	if attempt+1 >= maxAttempts || !retryableMethods[req.Method] {
+		return false
+	}
+
+	// A request with a body that can't be rewound (no GetBody) has already
+	// been drained by the failed attempt; retrying would send an empty body.
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		// A canceled or expired context means the caller gave up; retrying
+		// would just waste an attempt on a request nobody wants anymore.
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+		resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout
+}
+
+func (rt *RetryingRoundTripper) wait(ctx context.Context, attempt int, resp *http.Response) error {
+	delay := rt.backoff(attempt)
+	if resp != nil {
+		if retryAfter, ok := retryAfterDelay(resp); ok {
+			delay = retryAfter
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// backoff returns an exponentially increasing delay, capped at rt.retry.max
+// and jittered so that concurrent callers don't retry in lockstep.
+func (rt *RetryingRoundTripper) backoff(attempt int) time.Duration {
+	delay := rt.retry.base << uint(attempt-1)
+	if delay <= 0 || delay > rt.retry.max {
+		delay = rt.retry.max
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay) + 1))
+	return delay/2 + jitter/2
+}
+
+func retryAfterDelay(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when), true
+	}
+
+	return 0, false
+}
+
+func (rt *RetryingRoundTripper) breakerOpen(host string) bool {
+	if rt.breaker == nil {
+		return false
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	state, ok := rt.hosts[host]
+	if !ok {
+		return false
+	}
+
+	return time.Now().Before(state.openUntil)
+}
+
+func (rt *RetryingRoundTripper) recordResult(host string, success bool) {
+	if rt.breaker == nil {
+		return
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	state, ok := rt.hosts[host]
+	if !ok {
+		state = &hostBreakerState{}
+		rt.hosts[host] = state
+	}
+
+	if success {
+		state.consecutiveFailures = 0
+		state.openUntil = time.Time{}
+		return
+	}
+
+	state.consecutiveFailures++
+	if state.consecutiveFailures >= rt.breaker.threshold {
+		state.openUntil = time.Now().Add(rt.breaker.cooldown)
+		if rt.metrics != nil {
+			rt.metrics.circuitOpen.WithLabelValues(host).Inc()
+		}
+		log.WithFields(log.Fields{"host": host, "cooldown": rt.breaker.cooldown}).
+			Warn("circuit breaker open for internal API host")
+	}
+}
@@ -0,0 +1,261 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sequenceRoundTripper returns the next response/error from a fixed
+// sequence on each call, and records every request it sees.
+type sequenceRoundTripper struct {
+	mu       sync.Mutex
+	attempts []*http.Request
+	next     []roundTripResult
+}
+
+type roundTripResult struct {
+	resp *http.Response
+	err  error
+}
+
+func (rt *sequenceRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.attempts = append(rt.attempts, req)
+
+	if len(rt.next) == 0 {
+		return nil, errors.New("sequenceRoundTripper: no more responses queued")
+	}
+
+	result := rt.next[0]
+	rt.next = rt.next[1:]
+	return result.resp, result.err
+}
+
+func (rt *sequenceRoundTripper) attemptCount() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.attempts)
+}
+
+func newResponse(status int, header http.Header) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+		Header:     header,
+	}
+}
+
+func fastRetryPolicy(maxAttempts int) *retryPolicy {
+	return &retryPolicy{maxAttempts: maxAttempts, base: time.Millisecond, max: 5 * time.Millisecond}
+}
+
+// A body-less GET (the common shape for internal API lookups) must be
+// retryable without requiring GetBody, and must not panic.
+func TestRetryingRoundTripper_RetriesBodylessGET(t *testing.T) {
+	next := &sequenceRoundTripper{next: []roundTripResult{
+		{resp: newResponse(http.StatusServiceUnavailable, nil)},
+		{resp: newResponse(http.StatusOK, nil)},
+	}}
+
+	rt := newRetryingRoundTripper(next, fastRetryPolicy(3), nil)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/api/v4/internal/check", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected final status 200, got %d", resp.StatusCode)
+	}
+	if got := next.attemptCount(); got != 2 {
+		t.Fatalf("expected 2 attempts, got %d", got)
+	}
+}
+
+func TestRetryingRoundTripper_HonorsRetryAfterHeader(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "0")
+
+	next := &sequenceRoundTripper{next: []roundTripResult{
+		{resp: newResponse(http.StatusTooManyRequests, header)},
+		{resp: newResponse(http.StatusOK, nil)},
+	}}
+
+	rt := newRetryingRoundTripper(next, &retryPolicy{maxAttempts: 2, base: time.Hour, max: time.Hour}, nil)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	start := time.Now()
+	resp, err := rt.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected final status 200, got %d", resp.StatusCode)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("Retry-After: 0 should have short-circuited the backoff, took %s", elapsed)
+	}
+}
+
+func TestRetryingRoundTripper_CircuitBreakerTripsAndHalfOpens(t *testing.T) {
+	next := &sequenceRoundTripper{next: []roundTripResult{
+		{resp: newResponse(http.StatusServiceUnavailable, nil)},
+		{resp: newResponse(http.StatusServiceUnavailable, nil)},
+		{resp: newResponse(http.StatusOK, nil)},
+	}}
+
+	breaker := &circuitBreakerPolicy{threshold: 2, cooldown: 20 * time.Millisecond}
+	rt := newRetryingRoundTripper(next, nil, breaker)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Host = "example.com"
+
+	for i := 0; i < 2; i++ {
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("attempt %d: unexpected error: %v", i, err)
+		}
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d: expected 503, got %d", i, resp.StatusCode)
+		}
+	}
+
+	if _, err := rt.RoundTrip(req); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen once threshold is reached, got %v", err)
+	}
+	if got := next.attemptCount(); got != 2 {
+		t.Fatalf("circuit-open request must not reach next, attempts = %d", got)
+	}
+
+	time.Sleep(breaker.cooldown + 5*time.Millisecond)
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("half-open request: unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("half-open request: expected 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestRetryAfterDelay(t *testing.T) {
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", "2")
+
+	delay, ok := retryAfterDelay(resp)
+	if !ok || delay != 2*time.Second {
+		t.Fatalf("expected 2s delay from seconds form, got %s (ok=%v)", delay, ok)
+	}
+
+	resp = &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+
+	delay, ok = retryAfterDelay(resp)
+	if !ok || delay <= 0 || delay > time.Minute {
+		t.Fatalf("expected a positive delay under a minute from HTTP-date form, got %s (ok=%v)", delay, ok)
+	}
+
+	resp = &http.Response{Header: http.Header{}}
+	if _, ok := retryAfterDelay(resp); ok {
+		t.Fatalf("expected no delay when Retry-After is absent")
+	}
+}
+
+func TestRetryingRoundTripper_IntegrationWithHTTPTestServer(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	rt := newRetryingRoundTripper(http.DefaultTransport, fastRetryPolicy(2), nil)
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after retry, got %d", resp.StatusCode)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected the server to see 2 requests, saw %d", got)
+	}
+}
+
+func TestRetryingRoundTripper_RequestBodyWithoutGetBodyIsNotRetried(t *testing.T) {
+	next := &sequenceRoundTripper{next: []roundTripResult{
+		{resp: newResponse(http.StatusServiceUnavailable, nil)},
+	}}
+
+	rt := newRetryingRoundTripper(next, fastRetryPolicy(3), nil)
+
+	req, _ := http.NewRequest(http.MethodPut, "http://example.com", strings.NewReader("payload"))
+	req.GetBody = nil
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the single 503 to be returned unretried, got %d", resp.StatusCode)
+	}
+	if got := next.attemptCount(); got != 1 {
+		t.Fatalf("expected exactly 1 attempt when the body can't be rewound, got %d", got)
+	}
+}
+
+// http.NewRequest(method, url, http.NoBody) is a common idiom for a
+// body-less PUT/DELETE/POST. req.Body is then http.NoBody (not nil) and
+// req.GetBody is nil; retrying it must not attempt to call the absent
+// GetBody.
+func TestRetryingRoundTripper_RetriesNoBodyRequestWithoutPanicking(t *testing.T) {
+	next := &sequenceRoundTripper{next: []roundTripResult{
+		{resp: newResponse(http.StatusServiceUnavailable, nil)},
+		{resp: newResponse(http.StatusOK, nil)},
+	}}
+
+	rt := newRetryingRoundTripper(next, fastRetryPolicy(3), nil)
+
+	req, err := http.NewRequest(http.MethodPut, "http://example.com", http.NoBody)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip returned error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected final status 200, got %d", resp.StatusCode)
+	}
+	if got := next.attemptCount(); got != 2 {
+		t.Fatalf("expected 2 attempts, got %d", got)
+	}
+}